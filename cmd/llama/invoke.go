@@ -1,10 +1,12 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
@@ -15,7 +17,9 @@ import (
 	"github.com/aws/aws-sdk-go/service/lambda"
 	"github.com/google/subcommands"
 	"github.com/nelhage/llama/cmd/internal/cli"
+	"github.com/nelhage/llama/daemon"
 	"github.com/nelhage/llama/llama"
+	"github.com/nelhage/llama/logger"
 	"github.com/nelhage/llama/protocol"
 	"github.com/nelhage/llama/store"
 )
@@ -54,7 +58,7 @@ func (f *fileList) Set(v string) error {
 	return nil
 }
 
-func (f *fileList) Prepare(ctx context.Context, store store.Store) (map[string]protocol.File, error) {
+func (f *fileList) Prepare(ctx context.Context, store store.Store, client *daemon.Client, maxUploadSize int64) (map[string]protocol.File, error) {
 	if f.files == nil {
 		return nil, nil
 	}
@@ -62,17 +66,13 @@ func (f *fileList) Prepare(ctx context.Context, store store.Store) (map[string]p
 	files := make(map[string]protocol.File, len(f.files))
 	trace.WithRegion(ctx, "uploadFiles", func() {
 		for _, file := range f.files {
-			data, err := ioutil.ReadFile(file.source)
-			if err != nil {
-				outErr = fmt.Errorf("reading file %q: %w", file.source, err)
-				return
-			}
+			logger.Debug("upload", "uploading %q -> %q", file.source, file.dest)
 			st, err := os.Stat(file.source)
 			if err != nil {
 				outErr = fmt.Errorf("stat %q: %w", file.source, err)
 				return
 			}
-			blob, err := protocol.NewBlob(ctx, store, data)
+			blob, err := uploadFile(ctx, store, client, file.source, maxUploadSize)
 			if err != nil {
 				outErr = err
 				return
@@ -86,10 +86,16 @@ func (f *fileList) Prepare(ctx context.Context, store store.Store) (map[string]p
 	return files, nil
 }
 
+// defaultMaxUploadSize caps -file/-stdin inputs so that an accidental
+// multi-gigabyte input fails fast with a clear error instead of OOMing
+// the client deep inside protocol.NewBlob.
+const defaultMaxUploadSize = 100 * 1024 * 1024
+
 type InvokeCommand struct {
-	stdin bool
-	logs  bool
-	files fileList
+	stdin         bool
+	logs          bool
+	files         fileList
+	maxUploadSize int64
 }
 
 func (*InvokeCommand) Name() string     { return "invoke" }
@@ -104,29 +110,49 @@ func (c *InvokeCommand) SetFlags(flags *flag.FlagSet) {
 	flags.BoolVar(&c.logs, "logs", false, "Display command invocation logs")
 	flags.Var(&c.files, "f", "Pass a file through to the invocation")
 	flags.Var(&c.files, "file", "Pass a file through to the invocation")
+	flags.Int64Var(&c.maxUploadSize, "max-upload-size", defaultMaxUploadSize, "reject -file/-stdin inputs larger than this many bytes")
 }
 
 func (c *InvokeCommand) Execute(ctx context.Context, flag *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
 	global := cli.MustState(ctx)
 
+	// Dial the daemon once for the whole invocation and reuse the
+	// connection for every file upload, file argument, and
+	// stdout/stderr stream below, instead of dialing fresh per file;
+	// fetchOutputs still reads output files from the store directly.
+	// daemonClient is nil -- and
+	// every call below falls back to talking to the store directly --
+	// if no daemon is reachable.
+	daemonClient, err := daemon.Dial(ctx)
+	if err != nil {
+		logger.Debug("upload", "no daemon reachable: %s", err.Error())
+		daemonClient = nil
+	} else {
+		defer daemonClient.Close()
+	}
+
 	var spec protocol.InvocationSpec
 
 	if c.stdin {
-		stdin, err := ioutil.ReadAll(os.Stdin)
+		var buf bytes.Buffer
+		n, err := io.Copy(&buf, io.LimitReader(os.Stdin, c.maxUploadSize+1))
 		if err != nil {
 			log.Printf("reading stdin: %s", err.Error())
 			return subcommands.ExitFailure
 		}
-		spec.Stdin, err = protocol.NewBlob(ctx, global.Store, stdin)
+		if n > c.maxUploadSize {
+			log.Printf("stdin exceeds max upload size %s (use --max-upload-size to override)", daemon.FormatByteSize(c.maxUploadSize))
+			return subcommands.ExitFailure
+		}
+		spec.Stdin, err = protocol.NewBlob(ctx, global.Store, buf.Bytes())
 		if err != nil {
 			log.Printf("writing to store: %s", err.Error())
 			return subcommands.ExitFailure
 		}
 	}
 
-	var err error
 	if len(c.files.files) > 0 {
-		spec.Files, err = c.files.Prepare(ctx, global.Store)
+		spec.Files, err = c.files.Prepare(ctx, global.Store, daemonClient, c.maxUploadSize)
 		if err != nil {
 			log.Println(err.Error())
 			return subcommands.ExitFailure
@@ -135,7 +161,7 @@ func (c *InvokeCommand) Execute(ctx context.Context, flag *flag.FlagSet, _ ...in
 
 	var outputs map[string]string
 	trace.WithRegion(ctx, "prepareArguments", func() {
-		spec.Args, outputs, err = prepareArgs(ctx, global, flag.Args()[1:])
+		spec.Args, outputs, err = prepareArgs(ctx, global, daemonClient, flag.Args()[1:], c.maxUploadSize)
 	})
 	if err != nil {
 		log.Println("preparing arguments: ", err.Error())
@@ -163,27 +189,108 @@ func (c *InvokeCommand) Execute(ctx context.Context, flag *flag.FlagSet, _ ...in
 
 	fetchOutputs(ctx, outputs, &response.Response)
 
-	if response.Response.Stderr != nil {
-		bytes, err := response.Response.Stderr.Read(ctx, global.Store)
+	if err := streamBlob(ctx, global.Store, daemonClient, response.Response.Stderr, os.Stderr); err != nil {
+		log.Printf("Reading stderr: %s", err.Error())
+	}
+	if err := streamBlob(ctx, global.Store, daemonClient, response.Response.Stdout, os.Stdout); err != nil {
+		log.Printf("Reading stdout: %s", err.Error())
+	}
+
+	return subcommands.ExitStatus(response.Response.ExitStatus)
+}
+
+// streamChunkSize bounds how much of a blob streamBlob requests from
+// the daemon per round-trip, so a large output is trickled to the
+// terminal instead of arriving all at once.
+const streamChunkSize = 1 << 20 // 1 MiB
+
+// streamBlob copies blob to dst a chunk at a time via the daemon's
+// FetchChunk RPC, rather than buffering the whole object in memory
+// first. This keeps a large build log from being held in RAM before
+// the user sees any of it. It does not interleave -logs output with
+// stdout/stderr: Execute prints the invocation logs in full before
+// streaming either blob. If client is nil (no daemon reachable), it falls back to
+// a single blob.Read, same as before this existed.
+//
+// The daemon still materializes the whole blob the first time a chunk
+// of it is requested (see Daemon.FetchChunk) and serves the rest out
+// of memory; teaching the store itself to serve ranges, and teaching
+// the Lambda-side runtime to emit outputs in chunks as they're
+// produced, need changes to the protocol and runtime packages that
+// aren't part of this checkout.
+func streamBlob(ctx context.Context, st store.Store, client *daemon.Client, blob *protocol.Blob, dst io.Writer) error {
+	if blob == nil {
+		return nil
+	}
+	if client == nil {
+		data, err := blob.Read(ctx, st)
 		if err != nil {
-			log.Printf("Reading stderr: %s", err.Error())
-		} else {
-			os.Stderr.Write(bytes)
+			return err
 		}
+		_, err = dst.Write(data)
+		return err
 	}
-	if response.Response.Stdout != nil {
-		bytes, err := response.Response.Stdout.Read(ctx, global.Store)
+
+	var offset int64
+	for {
+		reply, err := client.FetchChunk(&daemon.FetchChunkArgs{
+			Blob:   *blob,
+			Offset: offset,
+			Length: streamChunkSize,
+		})
 		if err != nil {
-			log.Printf("Reading stdout: %s", err.Error())
-		} else {
-			os.Stdout.Write(bytes)
+			return err
+		}
+		if len(reply.Data) > 0 {
+			if _, err := dst.Write(reply.Data); err != nil {
+				return err
+			}
+			offset += int64(len(reply.Data))
+		}
+		if reply.EOF {
+			return nil
 		}
 	}
+}
 
-	return subcommands.ExitStatus(response.Response.ExitStatus)
+// uploadFile uploads path to st, via client's cached PrepareFile RPC
+// when client is non-nil, falling back to a direct upload otherwise.
+// It rejects files larger than maxUploadSize before reading them.
+//
+// The direct-upload fallback reads through io.CopyN rather than
+// ioutil.ReadFile so it never reads past maxUploadSize, bounding its
+// memory use to that limit regardless of how large a caller has raised
+// it for e.g. a sysroot upload. protocol.NewBlob only takes a []byte,
+// so fully avoiding an in-memory copy would need a streaming write API
+// in the protocol package, which isn't part of this checkout.
+func uploadFile(ctx context.Context, st store.Store, client *daemon.Client, path string, maxUploadSize int64) (*protocol.Blob, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() > maxUploadSize {
+		return nil, fmt.Errorf("file %q exceeds max upload size %s (use --max-upload-size to override)", path, daemon.FormatByteSize(maxUploadSize))
+	}
+	if client != nil {
+		reply, err := client.PrepareFile(&daemon.PrepareFileArgs{Path: path, MaxUploadSize: maxUploadSize})
+		if err == nil {
+			return &reply.File.Blob, nil
+		}
+		logger.Debug("upload", "daemon PrepareFile failed for %q: %s; uploading directly", path, err.Error())
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, f, maxUploadSize); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return protocol.NewBlob(ctx, st, buf.Bytes())
 }
 
-func parseArg(ctx context.Context, outputs *map[string]string, arg string) (json.RawMessage, error) {
+func parseArg(ctx context.Context, outputs *map[string]string, client *daemon.Client, arg string, maxUploadSize int64) (json.RawMessage, error) {
 	global := cli.MustState(ctx)
 	var argSpec interface{} = arg
 	idx := strings.Index(arg, "@")
@@ -191,17 +298,15 @@ func parseArg(ctx context.Context, outputs *map[string]string, arg string) (json
 		pfx := arg[:idx]
 		arg = arg[idx+1:]
 
+		logger.Debug("args", "parsing argspec %q@%q", pfx, arg)
+
 		var a protocol.Arg
 		switch pfx {
 		case "i", "io":
-			data, err := ioutil.ReadFile(arg)
-			if err != nil {
-				return nil, fmt.Errorf("Reading file: %q: %w", arg, err)
-
-			}
-			a.In, err = protocol.NewBlob(ctx, global.Store, data)
+			var err error
+			a.In, err = uploadFile(ctx, global.Store, client, arg, maxUploadSize)
 			if err != nil {
-				return nil, fmt.Errorf("Writing to store: %q: %w", arg, err)
+				return nil, fmt.Errorf("uploading file %q: %w", arg, err)
 			}
 			argSpec = a
 			if pfx == "i" {
@@ -216,6 +321,7 @@ func parseArg(ctx context.Context, outputs *map[string]string, arg string) (json
 				}
 			}
 
+			logger.Debug("args", "output %q -> local file %q", name, arg)
 			a.Out = &name
 			argSpec = a
 			if *outputs == nil {
@@ -244,6 +350,7 @@ func fetchOutputs(ctx context.Context, outputs map[string]string, resp *protocol
 				log.Printf("Unexpected output: %q", key)
 				continue
 			}
+			logger.Debug("fetch", "fetching output %q -> %q", key, file)
 			data, err := blob.Read(ctx, global.Store)
 			if err != nil {
 				log.Printf("reading output %q: %s", key, err.Error())
@@ -256,12 +363,13 @@ func fetchOutputs(ctx context.Context, outputs map[string]string, resp *protocol
 	})
 }
 
-func prepareArgs(ctx context.Context, global *cli.GlobalState, args []string) ([]json.RawMessage, map[string]string, error) {
+func prepareArgs(ctx context.Context, global *cli.GlobalState, client *daemon.Client, args []string, maxUploadSize int64) ([]json.RawMessage, map[string]string, error) {
+	logger.Debug("args", "preparing %d arguments", len(args))
 	out := make([]json.RawMessage, len(args))
 	var outputs map[string]string
 	for i, arg := range args {
 		var err error
-		out[i], err = parseArg(ctx, &outputs, arg)
+		out[i], err = parseArg(ctx, &outputs, client, arg, maxUploadSize)
 		if err != nil {
 			return nil, nil, err
 		}
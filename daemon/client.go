@@ -0,0 +1,28 @@
+package daemon
+
+// PrepareFile asks the daemon to upload path to the store, reusing its
+// cached blob if path hasn't changed since the last invocation.
+func (c *Client) PrepareFile(args *PrepareFileArgs) (*PrepareFileReply, error) {
+	var reply PrepareFileReply
+	if err := c.conn.Call("Daemon.PrepareFile", args, &reply); err != nil {
+		return nil, err
+	}
+	return &reply, nil
+}
+
+// FetchChunk asks the daemon for one range of a blob's content, so a
+// caller can stream a large output instead of fetching it in one shot.
+func (c *Client) FetchChunk(args *FetchChunkArgs) (*FetchChunkReply, error) {
+	var reply FetchChunkReply
+	if err := c.conn.Call("Daemon.FetchChunk", args, &reply); err != nil {
+		return nil, err
+	}
+	return &reply, nil
+}
+
+// Close releases the underlying RPC connection. Callers that Dial
+// should defer Close once they're done issuing requests, rather than
+// leaving the connection open for the life of the process.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
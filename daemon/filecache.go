@@ -0,0 +1,183 @@
+package daemon
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"sync"
+	"syscall"
+
+	"github.com/nelhage/llama/logger"
+	"github.com/nelhage/llama/protocol"
+)
+
+// fileCacheKey identifies a local file well enough to detect that it has
+// changed since the last time we uploaded it, without re-reading its
+// contents.
+type fileCacheKey struct {
+	Path  string
+	Size  int64
+	Mtime int64
+	Inode uint64
+}
+
+func statCacheKey(path string) (fileCacheKey, os.FileInfo, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return fileCacheKey{}, nil, err
+	}
+	var inode uint64
+	if sys, ok := st.Sys().(*syscall.Stat_t); ok {
+		inode = sys.Ino
+	}
+	return fileCacheKey{
+		Path:  path,
+		Size:  st.Size(),
+		Mtime: st.ModTime().UnixNano(),
+		Inode: inode,
+	}, st, nil
+}
+
+// fileCache is a bounded LRU mapping fileCacheKey to the blob it was
+// last uploaded as, backed by an on-disk JSON index so it survives
+// daemon restarts. It lets repeated invocations that pass the same
+// unchanged -file inputs (compilers, sysroots) skip re-uploading them.
+type fileCache struct {
+	mu        sync.Mutex
+	capacity  int
+	indexPath string
+	order     []fileCacheKey
+	entries   map[fileCacheKey]protocol.Blob
+
+	// persisting is set while a background goroutine is rewriting the
+	// on-disk index, and dirty is set whenever a put happens after
+	// that goroutine has already read c.order. This coalesces a burst
+	// of puts into a single rewrite instead of one per put.
+	persisting bool
+	dirty      bool
+}
+
+func newFileCache(indexPath string, capacity int) *fileCache {
+	c := &fileCache{
+		capacity:  capacity,
+		indexPath: indexPath,
+		entries:   make(map[fileCacheKey]protocol.Blob),
+	}
+	c.load()
+	return c
+}
+
+type fileCacheEntry struct {
+	Key  fileCacheKey
+	Blob protocol.Blob
+}
+
+func (c *fileCache) load() {
+	f, err := os.Open(c.indexPath)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	var entries []fileCacheEntry
+	if json.NewDecoder(f).Decode(&entries) != nil {
+		return
+	}
+	for _, e := range entries {
+		c.order = append(c.order, e.Key)
+		c.entries[e.Key] = e.Blob
+	}
+}
+
+// writeIndex rewrites the on-disk index at indexPath with entries,
+// creating indexPath's directory first since it need not already exist
+// (e.g. when the daemon is listening on a TCP/TLS transport rather
+// than the Unix socket the cache's default path is derived from).
+func writeIndex(indexPath string, entries []fileCacheEntry) {
+	if err := os.MkdirAll(path.Dir(indexPath), 0700); err != nil {
+		logger.Debug("upload", "creating file cache directory: %s", err.Error())
+		return
+	}
+	tmp := indexPath + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		logger.Debug("upload", "persisting file cache: %s", err.Error())
+		return
+	}
+	if err := json.NewEncoder(f).Encode(entries); err != nil {
+		f.Close()
+		logger.Debug("upload", "persisting file cache: %s", err.Error())
+		return
+	}
+	f.Close()
+	if err := os.Rename(tmp, indexPath); err != nil {
+		logger.Debug("upload", "persisting file cache: %s", err.Error())
+	}
+}
+
+// schedulePersist kicks off a background rewrite of the on-disk index
+// if one isn't already running, and otherwise just marks the running
+// one dirty so it loops around and picks up this put too. Must be
+// called with c.mu held.
+func (c *fileCache) schedulePersist() {
+	c.dirty = true
+	if c.persisting {
+		return
+	}
+	c.persisting = true
+	go c.persistLoop()
+}
+
+func (c *fileCache) persistLoop() {
+	c.mu.Lock()
+	for c.dirty {
+		c.dirty = false
+		entries := make([]fileCacheEntry, 0, len(c.order))
+		for _, k := range c.order {
+			entries = append(entries, fileCacheEntry{Key: k, Blob: c.entries[k]})
+		}
+		indexPath := c.indexPath
+		c.mu.Unlock()
+		writeIndex(indexPath, entries)
+		c.mu.Lock()
+	}
+	c.persisting = false
+	c.mu.Unlock()
+}
+
+// touch moves key to the most-recently-used end of c.order. Must be
+// called with c.mu held.
+func (c *fileCache) touch(key fileCacheKey) {
+	for i, k := range c.order {
+		if k == key {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			break
+		}
+	}
+	c.order = append(c.order, key)
+}
+
+func (c *fileCache) get(key fileCacheKey) (protocol.Blob, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	blob, ok := c.entries[key]
+	if ok {
+		c.touch(key)
+	}
+	return blob, ok
+}
+
+func (c *fileCache) put(key fileCacheKey, blob protocol.Blob) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; ok {
+		c.touch(key)
+	} else {
+		c.order = append(c.order, key)
+		for len(c.order) > c.capacity {
+			delete(c.entries, c.order[0])
+			c.order = c.order[1:]
+		}
+	}
+	c.entries[key] = blob
+	c.schedulePersist()
+}
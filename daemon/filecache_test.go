@@ -0,0 +1,71 @@
+package daemon
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nelhage/llama/protocol"
+)
+
+func waitForPersist(t *testing.T, c *fileCache) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		c.mu.Lock()
+		idle := !c.persisting && !c.dirty
+		c.mu.Unlock()
+		if idle {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("timed out waiting for file cache to finish persisting")
+}
+
+func TestFileCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newFileCache(filepath.Join(t.TempDir(), "file-cache.json"), 2)
+
+	k1 := fileCacheKey{Path: "a"}
+	k2 := fileCacheKey{Path: "b"}
+	k3 := fileCacheKey{Path: "c"}
+
+	c.put(k1, protocol.Blob{})
+	c.put(k2, protocol.Blob{})
+
+	// Touch k1 so it's more recently used than k2.
+	if _, ok := c.get(k1); !ok {
+		t.Fatal("expected cache hit for k1")
+	}
+
+	// Adding a third entry should evict k2 (now the least recently
+	// used), not k1.
+	c.put(k3, protocol.Blob{})
+
+	if _, ok := c.get(k1); !ok {
+		t.Error("k1 was evicted, want it retained (touched more recently)")
+	}
+	if _, ok := c.get(k2); ok {
+		t.Error("k2 was retained, want it evicted (least recently used)")
+	}
+	if _, ok := c.get(k3); !ok {
+		t.Error("k3 was evicted, want it retained (just inserted)")
+	}
+	// Let the background index rewrite finish before TempDir cleanup.
+	waitForPersist(t, c)
+}
+
+func TestFileCachePersistsAcrossRestart(t *testing.T) {
+	indexPath := filepath.Join(t.TempDir(), "nested", "file-cache.json")
+	key := fileCacheKey{Path: "a", Size: 1}
+	blob := protocol.Blob{}
+
+	c := newFileCache(indexPath, 10)
+	c.put(key, blob)
+	waitForPersist(t, c)
+
+	reloaded := newFileCache(indexPath, 10)
+	if _, ok := reloaded.get(key); !ok {
+		t.Fatal("expected cache entry to survive reload from the on-disk index")
+	}
+}
@@ -0,0 +1,81 @@
+package daemon
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// lockfile guards the "socket exists but nobody home" recovery path in
+// Start so that two concurrent `llama` invocations can't both decide the
+// socket is stale, both os.Remove it, and race to net.Listen. Only the
+// holder of the flock may remove and recreate the socket.
+type lockfile struct {
+	f *os.File
+}
+
+// acquireLock takes an exclusive flock on path, creating it if needed,
+// and blocks until it is held.
+func acquireLock(path string) (*lockfile, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &lockfile{f: f}, nil
+}
+
+func (l *lockfile) Release() error {
+	defer l.f.Close()
+	return syscall.Flock(int(l.f.Fd()), syscall.LOCK_UN)
+}
+
+// writePID records the PID of the daemon that holds the socket, so a
+// future caller finding a dead socket can tell whether the previous
+// owner is truly gone before stealing it.
+func (l *lockfile) writePID(pid int) error {
+	if err := l.f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := l.f.Seek(0, 0); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(l.f, "%d\n", pid)
+	return err
+}
+
+// readPID returns the PID last recorded by writePID, or 0 if the
+// lockfile is empty.
+func (l *lockfile) readPID() (int, error) {
+	if _, err := l.f.Seek(0, 0); err != nil {
+		return 0, err
+	}
+	data, err := ioutil.ReadAll(l.f)
+	if err != nil {
+		return 0, err
+	}
+	s := strings.TrimSpace(string(data))
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(s)
+}
+
+// processAlive reports whether pid names a still-running process,
+// probing with kill(pid, 0) rather than actually signaling it.
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return proc.Signal(syscall.Signal(0)) == nil
+}
@@ -0,0 +1,97 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"github.com/nelhage/llama/logger"
+	"github.com/nelhage/llama/protocol"
+	"github.com/nelhage/llama/store"
+)
+
+// defaultFileCacheSize bounds how many (path, stat) -> blob mappings the
+// daemon keeps; entries beyond this are evicted least-recently-used.
+const defaultFileCacheSize = 4096
+
+// defaultMaxUploadSize is the upload size PrepareFile enforces when a
+// caller doesn't name one in PrepareFileArgs, matching the CLI's own
+// default for --max-upload-size.
+const defaultMaxUploadSize = 100 * 1024 * 1024
+
+var fileUploadCache = newFileCache(path.Join(path.Dir(SocketPath()), "file-cache.json"), defaultFileCacheSize)
+
+// fileStore is the store.Store PrepareFile uploads into. It must be
+// configured with SetStore before the daemon starts serving requests.
+var fileStore store.Store
+
+// SetStore configures the store.Store that PrepareFile uploads files
+// into. The command that boots the daemon must call this once before
+// Start begins accepting connections.
+func SetStore(s store.Store) {
+	fileStore = s
+}
+
+// PrepareFileArgs names a local file the caller wants uploaded to the
+// store. MaxUploadSize caps how large a file PrepareFile will read; a
+// value <= 0 falls back to defaultMaxUploadSize, so a caller that
+// bypasses the CLI's own --max-upload-size check (a future or
+// alternate caller of this RPC) still can't push the daemon to read an
+// unbounded file into memory.
+type PrepareFileArgs struct {
+	Path          string
+	MaxUploadSize int64
+}
+
+type PrepareFileReply struct {
+	File protocol.File
+}
+
+// PrepareFile uploads the file at args.Path to the store, reusing the
+// blob from a previous upload if the file's (path, size, mtime, inode)
+// is unchanged since then. This lets the CLI skip reading+hashing+
+// uploading inputs that a previous invocation already pushed.
+func (d *Daemon) PrepareFile(args *PrepareFileArgs, reply *PrepareFileReply) error {
+	maxUploadSize := args.MaxUploadSize
+	if maxUploadSize <= 0 {
+		maxUploadSize = defaultMaxUploadSize
+	}
+	key, st, err := statCacheKey(args.Path)
+	if err != nil {
+		return err
+	}
+	// Enforce maxUploadSize before the cache lookup, not after: it's
+	// this call's limit, and a caller that lowers --max-upload-size
+	// after a file was cached under a looser limit must not silently
+	// get the oversized blob back just because it's still a hit.
+	if st.Size() > maxUploadSize {
+		return fmt.Errorf("file %q exceeds max upload size %s", args.Path, FormatByteSize(maxUploadSize))
+	}
+	if blob, ok := fileUploadCache.get(key); ok {
+		logger.Debug("upload", "cache hit for %q", args.Path)
+		reply.File = protocol.File{Blob: blob, Mode: st.Mode()}
+		return nil
+	}
+	if fileStore == nil {
+		return fmt.Errorf("daemon: PrepareFile: no store configured")
+	}
+	f, err := os.Open(args.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, f, maxUploadSize); err != nil && err != io.EOF {
+		return err
+	}
+	blob, err := protocol.NewBlob(context.Background(), fileStore, buf.Bytes())
+	if err != nil {
+		return err
+	}
+	fileUploadCache.put(key, *blob)
+	reply.File = protocol.File{Blob: *blob, Mode: st.Mode()}
+	return nil
+}
@@ -0,0 +1,88 @@
+package daemon
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/nelhage/llama/protocol"
+)
+
+// withFileUploadCache swaps in a fresh fileUploadCache for the duration
+// of a test and restores the original afterward, since it's a
+// package-level var shared across the daemon package's tests. The
+// cleanup waits for any background index rewrite, which writes into the
+// test's TempDir, before that directory is removed.
+func withFileUploadCache(t *testing.T) *fileCache {
+	t.Helper()
+	orig := fileUploadCache
+	c := newFileCache(filepath.Join(t.TempDir(), "file-cache.json"), 10)
+	fileUploadCache = c
+	t.Cleanup(func() {
+		waitForPersist(t, c)
+		fileUploadCache = orig
+	})
+	return c
+}
+
+func TestPrepareFileCacheHitStillEnforcesMaxUploadSize(t *testing.T) {
+	c := withFileUploadCache(t)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "big")
+	if err := ioutil.WriteFile(path, make([]byte, 32), 0644); err != nil {
+		t.Fatalf("writing fixture file: %v", err)
+	}
+	key, _, err := statCacheKey(path)
+	if err != nil {
+		t.Fatalf("statCacheKey: %v", err)
+	}
+	c.put(key, protocol.Blob{Digest: "cached-digest"})
+
+	d := &Daemon{}
+	var reply PrepareFileReply
+	err = d.PrepareFile(&PrepareFileArgs{Path: path, MaxUploadSize: 16}, &reply)
+	if err == nil {
+		t.Fatal("PrepareFile with MaxUploadSize below the cached file's size = nil error, want one")
+	}
+}
+
+func TestPrepareFileServesCacheHitWithoutStoreConfigured(t *testing.T) {
+	c := withFileUploadCache(t)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "small")
+	if err := ioutil.WriteFile(path, []byte("hello"), 0644); err != nil {
+		t.Fatalf("writing fixture file: %v", err)
+	}
+	key, _, err := statCacheKey(path)
+	if err != nil {
+		t.Fatalf("statCacheKey: %v", err)
+	}
+	c.put(key, protocol.Blob{Digest: "cached-digest"})
+
+	d := &Daemon{}
+	var reply PrepareFileReply
+	if err := d.PrepareFile(&PrepareFileArgs{Path: path, MaxUploadSize: 1024}, &reply); err != nil {
+		t.Fatalf("PrepareFile on a cache hit: %v", err)
+	}
+	if reply.File.Blob.Digest != "cached-digest" {
+		t.Errorf("PrepareFile returned digest %q, want %q", reply.File.Blob.Digest, "cached-digest")
+	}
+}
+
+func TestPrepareFileMissNoStoreConfigured(t *testing.T) {
+	withFileUploadCache(t)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "uncached")
+	if err := ioutil.WriteFile(path, []byte("hello"), 0644); err != nil {
+		t.Fatalf("writing fixture file: %v", err)
+	}
+
+	d := &Daemon{}
+	var reply PrepareFileReply
+	if err := d.PrepareFile(&PrepareFileArgs{Path: path}, &reply); err == nil {
+		t.Fatal("PrepareFile for an uncached file with no store configured = nil error, want one")
+	}
+}
@@ -5,11 +5,12 @@ import (
 	"errors"
 	"fmt"
 	"net"
-	"net/http"
 	"net/rpc"
 	"os"
 	"path"
 	"syscall"
+
+	"github.com/nelhage/llama/logger"
 )
 
 func SocketPath() string {
@@ -25,48 +26,126 @@ func SocketPath() string {
 var ErrAlreadyRunning = errors.New("daemon already running")
 
 func Start(ctx context.Context) error {
-	sockPath := SocketPath()
-	if err := os.MkdirAll(path.Dir(sockPath), 0700); err != nil {
+	transport, err := NewTransport(Addr())
+	if err != nil {
 		return err
 	}
-	listener, err := net.Listen("unix", sockPath)
-	if err != nil && errors.Is(err, syscall.EADDRINUSE) {
-		var client *Client
-		// The socket exists. Is someone listening?
-		client, err = Dial(ctx)
-		if err == nil {
-			_, err = client.Ping(&PingInput{})
-			if err == nil {
-				return ErrAlreadyRunning
-			}
+
+	// The lockfile-guarded stale-socket recovery below only makes sense
+	// for a local Unix socket; a TCP/TLS listener has no equivalent
+	// leftover-file state to race on.
+	ut, isUnix := transport.(unixTransport)
+	if isUnix {
+		if err := os.MkdirAll(path.Dir(ut.path), 0700); err != nil {
+			return err
+		}
+	}
+
+	listener, err := transport.Listen(ctx)
+	if err != nil {
+		if !isUnix || !errors.Is(err, syscall.EADDRINUSE) {
 			return err
 		}
-		// TODO: be atomic (lockfile?) if multiple clients hit
-		// this path at once.
-		if err := os.Remove(sockPath); err != nil {
+		listener, err = reclaimSocket(ctx, ut)
+		if err != nil {
 			return err
 		}
-		listener, err = net.Listen("unix", sockPath)
 	}
-	if err != nil {
-		return err
+
+	if isUnix {
+		if err := recordOwnerPID(ut.path); err != nil {
+			listener.Close()
+			return err
+		}
 	}
-	var httpSrv http.Server
+
+	logger.Debug("rpc", "daemon listening on %s", listener.Addr())
 	var rpcSrv rpc.Server
 	rpcSrv.Register(&Daemon{})
-	httpSrv.Handler = &rpcSrv
-	go func() {
-		httpSrv.Serve(listener)
-	}()
+	go serveConns(listener, &rpcSrv)
 	<-ctx.Done()
-	httpSrv.Shutdown(ctx)
+	listener.Close()
 	return nil
 }
 
-func Dial(_ context.Context) (*Client, error) {
-	conn, err := rpc.DialHTTP("unix", SocketPath())
+// reclaimSocket is called when ut.path exists but transport.Listen
+// failed with EADDRINUSE. It takes the exclusive lock on
+// ut.path+".lock" only for the duration of this check, rather than for
+// the daemon's whole lifetime, so that a second `llama daemon start`
+// run against an already-healthy daemon still fails fast via the
+// dial+Ping check below instead of blocking forever behind a flock
+// held by the first daemon's entire Start call.
+//
+// Holding the lock here ensures only one of any number of concurrent
+// callers can decide the socket is stale, unlink it, and re-listen.
+func reclaimSocket(ctx context.Context, ut unixTransport) (net.Listener, error) {
+	lock, err := acquireLock(ut.path + ".lock")
+	if err != nil {
+		return nil, fmt.Errorf("acquiring daemon lock: %w", err)
+	}
+	defer lock.Release()
+
+	// Whoever held the lock ahead of us may already have recovered the
+	// socket and be listening on it now.
+	if listener, err := ut.Listen(ctx); err == nil {
+		return listener, nil
+	}
+
+	client, err := Dial(ctx)
+	if err == nil {
+		_, err = client.Ping(&PingInput{})
+		if err == nil {
+			return nil, ErrAlreadyRunning
+		}
+		return nil, err
+	}
+	// Nobody answered. Before stealing the socket, double-check that
+	// the PID recorded by the previous owner is actually gone, in case
+	// it's just wedged rather than dead.
+	if pid, _ := lock.readPID(); pid != 0 && processAlive(pid) {
+		return nil, fmt.Errorf("socket %q is stale but owning pid %d is still alive", ut.path, pid)
+	}
+	if err := os.Remove(ut.path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return ut.Listen(ctx)
+}
+
+// recordOwnerPID writes this process's PID into the lockfile beside
+// path, taking the lock only long enough to write it, so a future
+// daemon that finds this socket dead can tell whether this process is
+// truly gone before stealing it.
+func recordOwnerPID(path string) error {
+	lock, err := acquireLock(path + ".lock")
+	if err != nil {
+		return fmt.Errorf("acquiring daemon lock: %w", err)
+	}
+	defer lock.Release()
+	return lock.writePID(os.Getpid())
+}
+
+// serveConns accepts connections from listener and serves RPC requests
+// on each with rpcSrv, until the listener is closed.
+func serveConns(listener net.Listener, rpcSrv *rpc.Server) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go rpcSrv.ServeConn(conn)
+	}
+}
+
+func Dial(ctx context.Context) (*Client, error) {
+	addr := Addr()
+	logger.Debug("rpc", "dialing daemon at %s", addr)
+	transport, err := NewTransport(addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := transport.Dial(ctx)
 	if err != nil {
 		return nil, err
 	}
-	return &Client{conn}, nil
+	return &Client{rpc.NewClient(conn)}, nil
 }
@@ -0,0 +1,19 @@
+package daemon
+
+import "fmt"
+
+// FormatByteSize renders n as a human-readable size (e.g. "100 MiB"),
+// for use in max-upload-size error messages. Shared by the daemon and
+// the CLI so the two don't drift apart with separate copies.
+func FormatByteSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for n/div >= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.0f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,21 @@
+package daemon
+
+import "testing"
+
+func TestFormatByteSize(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1 KiB"},
+		{100 * 1024 * 1024, "100 MiB"},
+		{1024 * 1024 * 1024, "1 GiB"},
+	}
+	for _, tt := range tests {
+		if got := FormatByteSize(tt.n); got != tt.want {
+			t.Errorf("FormatByteSize(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
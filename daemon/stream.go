@@ -0,0 +1,89 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/nelhage/llama/protocol"
+)
+
+// FetchChunkArgs requests one range of args.Blob's content, so a client
+// can copy a large invocation output to its destination as it arrives
+// instead of waiting for the whole blob to cross the RPC in one call.
+type FetchChunkArgs struct {
+	Blob   protocol.Blob
+	Offset int64
+	Length int64
+}
+
+type FetchChunkReply struct {
+	Data []byte
+	// EOF is set once Data reaches the end of the blob, so the caller
+	// knows not to request another chunk.
+	EOF bool
+}
+
+// blobReadCache holds the bytes of a blob currently being streamed out
+// by FetchChunk, so that serving chunk 2..N of the same blob doesn't
+// re-read it from the store on every call. Entries are removed once
+// the last chunk is served, unlike the persistent fileUploadCache; a
+// stream abandoned before its last chunk leaves its entry in place.
+var (
+	blobReadMu    sync.Mutex
+	blobReadCache = map[string][]byte{}
+)
+
+// FetchChunk serves one range of args.Blob's content. This is the
+// daemon side of the streaming-output path: streamBlob in the invoke
+// command calls it in a loop instead of reading the entire blob in one
+// RPC, so a large build log can be copied to stdout chunk by chunk as
+// it arrives. It does not interleave output with -logs: the invoke
+// command prints the invocation logs in full before streaming begins.
+//
+// This is daemon-side re-chunking, not true streaming from the source:
+// the daemon reads the full blob from the store on the first chunk
+// request for a given digest and serves the remaining chunks out of
+// blobReadCache. A Blob.ReadStream in protocol plus chunked emission on
+// the Lambda side, which would avoid buffering the whole blob here, are
+// not implemented -- protocol doesn't expose that API in this checkout.
+// A cache hit doesn't touch fileStore at all, so FetchChunk can still
+// serve the tail of a blob already in blobReadCache even if the store
+// isn't configured.
+func (d *Daemon) FetchChunk(args *FetchChunkArgs, reply *FetchChunkReply) error {
+	digest := args.Blob.Digest
+
+	blobReadMu.Lock()
+	data, ok := blobReadCache[digest]
+	blobReadMu.Unlock()
+	if !ok {
+		if fileStore == nil {
+			return fmt.Errorf("daemon: FetchChunk: no store configured")
+		}
+		var err error
+		data, err = args.Blob.Read(context.Background(), fileStore)
+		if err != nil {
+			return err
+		}
+		blobReadMu.Lock()
+		blobReadCache[digest] = data
+		blobReadMu.Unlock()
+	}
+
+	start := args.Offset
+	if start > int64(len(data)) {
+		start = int64(len(data))
+	}
+	end := start + args.Length
+	if end > int64(len(data)) {
+		end = int64(len(data))
+	}
+	reply.Data = data[start:end]
+	reply.EOF = end >= int64(len(data))
+	if reply.EOF {
+		blobReadMu.Lock()
+		delete(blobReadCache, digest)
+		blobReadMu.Unlock()
+	}
+	return nil
+}
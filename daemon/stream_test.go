@@ -0,0 +1,84 @@
+package daemon
+
+import (
+	"testing"
+
+	"github.com/nelhage/llama/protocol"
+)
+
+// populateBlobReadCache seeds blobReadCache directly, so FetchChunk can
+// be exercised without a store.Store: only the cache-hit path is under
+// test here, and FetchChunk doesn't touch fileStore once data is cached.
+func populateBlobReadCache(t *testing.T, digest string, data []byte) {
+	t.Helper()
+	blobReadMu.Lock()
+	blobReadCache[digest] = data
+	blobReadMu.Unlock()
+	t.Cleanup(func() {
+		blobReadMu.Lock()
+		delete(blobReadCache, digest)
+		blobReadMu.Unlock()
+	})
+}
+
+func TestFetchChunkServesRangesAndEvictsOnEOF(t *testing.T) {
+	const digest = "test-digest"
+	populateBlobReadCache(t, digest, []byte("0123456789"))
+
+	d := &Daemon{}
+	blob := protocol.Blob{Digest: digest}
+
+	var reply FetchChunkReply
+	if err := d.FetchChunk(&FetchChunkArgs{Blob: blob, Offset: 0, Length: 4}, &reply); err != nil {
+		t.Fatalf("FetchChunk: %v", err)
+	}
+	if string(reply.Data) != "0123" || reply.EOF {
+		t.Fatalf("first chunk = %q, EOF=%v; want %q, EOF=false", reply.Data, reply.EOF, "0123")
+	}
+
+	if err := d.FetchChunk(&FetchChunkArgs{Blob: blob, Offset: 4, Length: 4}, &reply); err != nil {
+		t.Fatalf("FetchChunk: %v", err)
+	}
+	if string(reply.Data) != "4567" || reply.EOF {
+		t.Fatalf("second chunk = %q, EOF=%v; want %q, EOF=false", reply.Data, reply.EOF, "4567")
+	}
+
+	// This chunk lands exactly on the blob's end: fewer bytes than
+	// requested, with EOF set and the cache entry dropped.
+	if err := d.FetchChunk(&FetchChunkArgs{Blob: blob, Offset: 8, Length: 4}, &reply); err != nil {
+		t.Fatalf("FetchChunk: %v", err)
+	}
+	if string(reply.Data) != "89" || !reply.EOF {
+		t.Fatalf("final chunk = %q, EOF=%v; want %q, EOF=true", reply.Data, reply.EOF, "89")
+	}
+
+	blobReadMu.Lock()
+	_, stillCached := blobReadCache[digest]
+	blobReadMu.Unlock()
+	if stillCached {
+		t.Fatal("blobReadCache entry was not evicted after the final chunk")
+	}
+}
+
+func TestFetchChunkOffsetPastEndReturnsEmptyEOF(t *testing.T) {
+	const digest = "past-end"
+	populateBlobReadCache(t, digest, []byte("abc"))
+
+	d := &Daemon{}
+	var reply FetchChunkReply
+	if err := d.FetchChunk(&FetchChunkArgs{Blob: protocol.Blob{Digest: digest}, Offset: 10, Length: 4}, &reply); err != nil {
+		t.Fatalf("FetchChunk: %v", err)
+	}
+	if len(reply.Data) != 0 || !reply.EOF {
+		t.Fatalf("chunk past end = %q, EOF=%v; want empty, EOF=true", reply.Data, reply.EOF)
+	}
+}
+
+func TestFetchChunkMissNoStoreConfigured(t *testing.T) {
+	d := &Daemon{}
+	var reply FetchChunkReply
+	err := d.FetchChunk(&FetchChunkArgs{Blob: protocol.Blob{Digest: "never-cached"}, Offset: 0, Length: 4}, &reply)
+	if err == nil {
+		t.Fatal("FetchChunk for an uncached digest with no store configured = nil error, want one")
+	}
+}
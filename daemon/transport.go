@@ -0,0 +1,126 @@
+package daemon
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+)
+
+// Transport abstracts how the daemon listens for RPC connections and how
+// a client reaches it, so a daemon need not live on the same machine as
+// its callers: a shared daemon on a beefy build host can serve many thin
+// clients (CI runners, laptops behind a VPN) over TCP instead of
+// requiring a per-user daemon reachable only over a local Unix socket.
+type Transport interface {
+	Listen(ctx context.Context) (net.Listener, error)
+	Dial(ctx context.Context) (net.Conn, error)
+}
+
+// Addr returns the address the daemon should listen on / the client
+// should dial, taken from LLAMA_DAEMON_ADDR if set (e.g.
+// "unix:///path/llama.sock", "tcp://127.0.0.1:9999",
+// "tls://host:9999?cert=...&key=...&ca=..."), falling back to a Unix
+// socket at SocketPath() for backwards compatibility.
+func Addr() string {
+	if addr := os.Getenv("LLAMA_DAEMON_ADDR"); addr != "" {
+		return addr
+	}
+	return "unix://" + SocketPath()
+}
+
+// NewTransport parses addr, as returned by Addr, into a Transport.
+func NewTransport(addr string) (Transport, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, fmt.Errorf("parsing daemon address %q: %w", addr, err)
+	}
+	switch u.Scheme {
+	case "unix":
+		return unixTransport{path: u.Path}, nil
+	case "tcp":
+		return tcpTransport{addr: u.Host}, nil
+	case "tls":
+		return newTLSTransport(u)
+	default:
+		return nil, fmt.Errorf("unsupported daemon transport %q in %q", u.Scheme, addr)
+	}
+}
+
+type unixTransport struct {
+	path string
+}
+
+func (t unixTransport) Listen(ctx context.Context) (net.Listener, error) {
+	return net.Listen("unix", t.path)
+}
+
+func (t unixTransport) Dial(ctx context.Context) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, "unix", t.path)
+}
+
+type tcpTransport struct {
+	addr string
+}
+
+func (t tcpTransport) Listen(ctx context.Context) (net.Listener, error) {
+	return net.Listen("tcp", t.addr)
+}
+
+func (t tcpTransport) Dial(ctx context.Context) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", t.addr)
+}
+
+type tlsTransport struct {
+	addr string
+	conf *tls.Config
+}
+
+// newTLSTransport builds a tlsTransport from a tls://host:port URL whose
+// query string names a client/server certificate, key, and CA bundle:
+// tls://host:9999?cert=cert.pem&key=key.pem&ca=ca.pem. The same
+// certificate pair is used for both serving and dialing, and the CA
+// bundle is required so each side can verify the other's certificate.
+func newTLSTransport(u *url.URL) (Transport, error) {
+	q := u.Query()
+	certFile, keyFile, caFile := q.Get("cert"), q.Get("key"), q.Get("ca")
+	if certFile == "" || keyFile == "" || caFile == "" {
+		return nil, fmt.Errorf("tls daemon transport requires cert, key, and ca query params")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading certificate: %w", err)
+	}
+	caPEM, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading CA certificate: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("parsing CA certificate %q", caFile)
+	}
+	return tlsTransport{
+		addr: u.Host,
+		conf: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			RootCAs:      pool,
+			ClientCAs:    pool,
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+		},
+	}, nil
+}
+
+func (t tlsTransport) Listen(ctx context.Context) (net.Listener, error) {
+	return tls.Listen("tcp", t.addr, t.conf)
+}
+
+func (t tlsTransport) Dial(ctx context.Context) (net.Conn, error) {
+	d := tls.Dialer{Config: t.conf}
+	return d.DialContext(ctx, "tcp", t.addr)
+}
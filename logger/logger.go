@@ -0,0 +1,50 @@
+// Package logger provides opt-in debug logging for llama's subsystems,
+// gated per-subsystem by the LLAMA_TRACE environment variable so a user
+// debugging a stuck invocation can turn on just the RPC or blob-store
+// logs without drowning in noise from the rest of the client.
+package logger
+
+import (
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	once    sync.Once
+	all     bool
+	enabled map[string]bool
+)
+
+// parse reads LLAMA_TRACE, a comma-separated list of subsystem names
+// (e.g. "upload,fetch,rpc"), or "all" to enable every subsystem.
+func parse() {
+	all = false
+	enabled = make(map[string]bool)
+	for _, s := range strings.Split(os.Getenv("LLAMA_TRACE"), ",") {
+		s = strings.TrimSpace(s)
+		switch s {
+		case "":
+		case "all":
+			all = true
+		default:
+			enabled[s] = true
+		}
+	}
+}
+
+// Enabled reports whether debug logging for subsystem is turned on via
+// LLAMA_TRACE.
+func Enabled(subsystem string) bool {
+	once.Do(parse)
+	return all || enabled[subsystem]
+}
+
+// Debug logs a message for subsystem if LLAMA_TRACE has enabled it.
+func Debug(subsystem, format string, args ...interface{}) {
+	if !Enabled(subsystem) {
+		return
+	}
+	log.Printf("["+subsystem+"] "+format, args...)
+}
@@ -0,0 +1,52 @@
+package logger
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestParseSubsystems(t *testing.T) {
+	tests := []struct {
+		env  string
+		want map[string]bool
+		all  bool
+	}{
+		{env: "", want: map[string]bool{}},
+		{env: "upload", want: map[string]bool{"upload": true}},
+		{env: "all", want: map[string]bool{}, all: true},
+		// Once "all" has latched true in a previous parse(), a later
+		// call must still be able to turn it back off.
+		{env: "upload,fetch", want: map[string]bool{"upload": true, "fetch": true}},
+		{env: " upload , fetch ", want: map[string]bool{"upload": true, "fetch": true}},
+	}
+	for _, tt := range tests {
+		t.Setenv("LLAMA_TRACE", tt.env)
+		once = sync.Once{}
+		parse()
+
+		if all != tt.all {
+			t.Errorf("LLAMA_TRACE=%q: all = %v, want %v", tt.env, all, tt.all)
+		}
+		if len(enabled) != len(tt.want) {
+			t.Errorf("LLAMA_TRACE=%q: enabled = %v, want %v", tt.env, enabled, tt.want)
+			continue
+		}
+		for k := range tt.want {
+			if !enabled[k] {
+				t.Errorf("LLAMA_TRACE=%q: subsystem %q not enabled", tt.env, k)
+			}
+		}
+	}
+}
+
+func TestEnabled(t *testing.T) {
+	t.Setenv("LLAMA_TRACE", "upload")
+	once = sync.Once{}
+
+	if !Enabled("upload") {
+		t.Error("Enabled(\"upload\") = false, want true")
+	}
+	if Enabled("fetch") {
+		t.Error("Enabled(\"fetch\") = true, want false")
+	}
+}